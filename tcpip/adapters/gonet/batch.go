@@ -0,0 +1,131 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonet
+
+import (
+	"errors"
+
+	"github.com/google/netstack/tcpip"
+	"github.com/google/netstack/tcpip/buffer"
+	"github.com/google/netstack/waiter"
+)
+
+// defaultBatchSize is the maximum number of buffers ReadVectors and
+// WriteVectors will coalesce into a single trip through the endpoint's
+// Read/Write path.
+const defaultBatchSize = 128
+
+// BatchSize returns the maximum number of buffers that ReadVectors and
+// WriteVectors will submit to the endpoint in a single call.
+func (c *Conn) BatchSize() int {
+	return defaultBatchSize
+}
+
+// ReadVectors reads into the given buffers, filling them in order, using at
+// most BatchSize() buffers per trip through the endpoint's Read path under a
+// single waiter registration. It returns, for each buffer consulted, the
+// number of bytes placed into it; len(ns) may be less than len(bufs) if an
+// error or deadline cuts the batch short.
+func (c *Conn) ReadVectors(bufs [][]byte) (ns []int, err error) {
+	if len(bufs) == 0 {
+		return nil, nil
+	}
+
+	deadline := c.readCancel()
+
+	select {
+	case <-deadline:
+		return nil, newOpError("read", &timeoutError{})
+	default:
+	}
+
+	e, ch := waiter.NewChannelEntry(nil)
+	c.wq.EventRegister(&e, waiter.EventIn)
+	defer c.wq.EventUnregister(&e)
+
+	ns = make([]int, 0, len(bufs))
+	for len(ns) < len(bufs) && len(ns) < c.BatchSize() {
+		v, err := c.ep.Read(nil)
+		if err == nil {
+			ns = append(ns, copy(bufs[len(ns)], v))
+			continue
+		}
+
+		if err == tcpip.ErrWouldBlock {
+			if len(ns) > 0 {
+				// Return what we already have rather than
+				// blocking for more.
+				return ns, nil
+			}
+
+			select {
+			case <-ch:
+				continue
+			case <-deadline:
+				return ns, newOpError("read", &timeoutError{})
+			}
+		}
+
+		// A terminal error: still report it even though part of the
+		// batch already succeeded, so the caller learns the
+		// connection is gone rather than mistaking this for a
+		// clean short read.
+		return ns, newOpError("read", errors.New(err.String()))
+	}
+
+	return ns, nil
+}
+
+// WriteVectors writes the given buffers, in order, using at most
+// BatchSize() buffers per trip through the endpoint's Write path under a
+// single waiter registration. It returns, for each buffer submitted, the
+// number of bytes written from it.
+func (c *Conn) WriteVectors(bufs [][]byte) (ns []int, err error) {
+	if len(bufs) == 0 {
+		return nil, nil
+	}
+
+	deadline := c.writeCancel()
+
+	select {
+	case <-deadline:
+		return nil, newOpError("write", &timeoutError{})
+	default:
+	}
+
+	e, ch := waiter.NewChannelEntry(nil)
+	c.wq.EventRegister(&e, waiter.EventOut)
+	defer c.wq.EventUnregister(&e)
+
+	ns = make([]int, 0, len(bufs))
+	for len(ns) < len(bufs) && len(ns) < c.BatchSize() {
+		n, err := c.ep.Write(buffer.View(bufs[len(ns)]), nil)
+		if err == nil {
+			ns = append(ns, int(n))
+			continue
+		}
+
+		if err == tcpip.ErrWouldBlock {
+			if len(ns) > 0 {
+				return ns, nil
+			}
+
+			select {
+			case <-ch:
+				continue
+			case <-deadline:
+				return ns, newOpError("write", &timeoutError{})
+			}
+		}
+
+		// A terminal error: still report it even though part of the
+		// batch already succeeded, so the caller learns the
+		// connection is gone rather than mistaking this for a
+		// clean short write.
+		return ns, newOpError("write", errors.New(err.String()))
+	}
+
+	return ns, nil
+}
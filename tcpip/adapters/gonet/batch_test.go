@@ -0,0 +1,192 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonet
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/netstack/tcpip"
+	"github.com/google/netstack/tcpip/network/ipv4"
+)
+
+// connPair returns two connected Conns over a loopback stack: the dialer's
+// end and the accepted end.
+func connPair(tb testing.TB) (*Conn, *Conn) {
+	tb.Helper()
+
+	s, err := newLoopbackStack()
+	if err != nil {
+		tb.Fatalf("newLoopbackStack() = %v", err)
+	}
+
+	addr := tcpip.FullAddress{NIC: NICID, Addr: tcpip.Address(net.IPv4(169, 254, 10, 1).To4()), Port: 11211}
+	s.AddAddress(NICID, ipv4.ProtocolNumber, addr.Addr)
+
+	l, err := NewListener(s, addr, ipv4.ProtocolNumber)
+	if err != nil {
+		tb.Fatalf("NewListener() = %v", err)
+	}
+
+	type acceptResult struct {
+		c   net.Conn
+		err error
+	}
+	ch := make(chan acceptResult, 1)
+	go func() {
+		c, err := l.Accept()
+		ch <- acceptResult{c, err}
+	}()
+
+	tc, err := connect(s, addr)
+	if err != nil {
+		tb.Fatalf("connect() = %v", err)
+	}
+
+	res := <-ch
+	if res.err != nil {
+		tb.Fatalf("l.Accept() = %v", res.err)
+	}
+
+	return NewConn(tc.wq, tc.ep), res.c.(*Conn)
+}
+
+// TestReadVectorsCloseReader tests that Close() wakes a blocked
+// ReadVectors(), returning the *net.OpError the single-buffer Read path
+// returns in TestCloseReaderWithForwarder, rather than a bare nil error.
+func TestReadVectorsCloseReader(t *testing.T) {
+	client, server := connPair(t)
+	defer client.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		time.AfterFunc(time.Millisecond*50, func() {
+			t.Log("server.Close()")
+			server.Close()
+			t.Log("server.Close() ok")
+		})
+
+		bufs := [][]byte{make([]byte, 256)}
+		t.Log("server.ReadVectors()")
+		ns, err := server.ReadVectors(bufs)
+		got, ok := err.(*net.OpError)
+		want := tcpip.ErrConnectionAborted
+		if len(ns) != 0 || !ok || got.Err.Error() != want.String() {
+			t.Errorf("server.ReadVectors() = (%v, %v), want (nil, OpError(%v))", ns, err, want)
+		}
+		t.Logf("server.ReadVectors() = %v, %v", ns, err)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("server.ReadVectors() didn't unblock")
+	}
+}
+
+// TestReadVectorsDeadlineChange tests that changing the deadline affects a
+// currently blocked ReadVectors.
+func TestReadVectorsDeadlineChange(t *testing.T) {
+	client, server := connPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	server.SetDeadline(time.Now().Add(time.Minute))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		time.AfterFunc(time.Millisecond*50, func() {
+			t.Log("server.SetDeadline()")
+			server.SetDeadline(time.Now().Add(time.Millisecond * 10))
+			t.Log("server.SetDeadline() ok")
+		})
+
+		bufs := [][]byte{make([]byte, 256)}
+		t.Log("server.ReadVectors()")
+		ns, err := server.ReadVectors(bufs)
+		got, ok := err.(*net.OpError)
+		want := "i/o timeout"
+		if len(ns) != 0 || !ok || got.Err == nil || got.Err.Error() != want {
+			t.Errorf("server.ReadVectors() = (%v, %v), want (nil, OpError(%s))", ns, err, want)
+		}
+		t.Logf("server.ReadVectors() = %v, %v", ns, err)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Millisecond * 500):
+		t.Errorf("server.ReadVectors() didn't unblock")
+	}
+}
+
+// BenchmarkReadWrite exercises the one-buffer-at-a-time Read/Write path as a
+// baseline for BenchmarkReadWriteVectors.
+func BenchmarkReadWrite(b *testing.B) {
+	client, server := connPair(b)
+	defer client.Close()
+	defer server.Close()
+
+	buf := make([]byte, 1024)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		rbuf := make([]byte, 1024)
+		for i := 0; i < b.N; i++ {
+			if _, err := server.Read(rbuf); err != nil {
+				return
+			}
+		}
+	}()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.Write(buf); err != nil {
+			b.Fatalf("Write() = %v", err)
+		}
+	}
+	<-done
+}
+
+// BenchmarkReadWriteVectors exercises the vectorized path with a batch of 16
+// buffers per trip through the endpoint.
+func BenchmarkReadWriteVectors(b *testing.B) {
+	const batch = 16
+
+	client, server := connPair(b)
+	defer client.Close()
+	defer server.Close()
+
+	bufs := make([][]byte, batch)
+	for i := range bufs {
+		bufs[i] = make([]byte, 1024)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		rbufs := make([][]byte, batch)
+		for i := range rbufs {
+			rbufs[i] = make([]byte, 1024)
+		}
+		for i := 0; i < b.N; i += batch {
+			if _, err := server.ReadVectors(rbufs); err != nil {
+				return
+			}
+		}
+	}()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i += batch {
+		if _, err := client.WriteVectors(bufs); err != nil {
+			b.Fatalf("WriteVectors() = %v", err)
+		}
+	}
+	<-done
+}
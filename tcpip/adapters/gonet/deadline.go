@@ -0,0 +1,70 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonet
+
+import (
+	"sync"
+	"time"
+)
+
+// deadlineTimer holds the state needed to implement a single cancellable
+// deadline, such as a read or write deadline: a timer that, when it fires
+// (or when the deadline is moved into the past), closes cancelCh to wake
+// anything selecting on it. PacketConn keeps one of these per direction.
+type deadlineTimer struct {
+	mu       sync.Mutex
+	cancelCh chan struct{}
+	timer    *time.Timer
+}
+
+// init initializes (or reinitializes) t for use. It must be called before
+// any other method.
+func (t *deadlineTimer) init() {
+	t.cancelCh = make(chan struct{})
+}
+
+// cancel returns the channel that's closed when the deadline set by the most
+// recent call to setDeadline expires.
+func (t *deadlineTimer) cancel() <-chan struct{} {
+	t.mu.Lock()
+	ch := t.cancelCh
+	t.mu.Unlock()
+	return ch
+}
+
+// setDeadline arms or disarms the timer for deadline, replacing cancelCh
+// with a fresh, unclosed channel whenever the previous one may already have
+// fired.
+func (t *deadlineTimer) setDeadline(deadline time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.timer != nil && !t.timer.Stop() {
+		t.cancelCh = make(chan struct{})
+	}
+
+	// A deadline already in the past closes cancelCh without arming a
+	// timer, so check for that case too.
+	select {
+	case <-t.cancelCh:
+		t.cancelCh = make(chan struct{})
+	default:
+	}
+
+	if deadline.IsZero() {
+		return
+	}
+
+	timeout := deadline.Sub(time.Now())
+	if timeout <= 0 {
+		close(t.cancelCh)
+		return
+	}
+
+	ch := t.cancelCh
+	t.timer = time.AfterFunc(timeout, func() {
+		close(ch)
+	})
+}
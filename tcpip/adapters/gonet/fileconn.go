@@ -0,0 +1,86 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonet
+
+import (
+	"errors"
+	"net"
+
+	"github.com/google/netstack/tcpip"
+	"github.com/google/netstack/tcpip/transport/tcp"
+	"github.com/google/netstack/waiter"
+)
+
+// FileConn adopts an already-connected tcpip.Endpoint, such as one handed
+// out by a tcp.Forwarder callback, wrapping it in a net.Conn. It is the
+// gonet analogue of the standard library's net.FileConn.
+//
+// Ownership of ep and wq passes to the returned Conn; the caller must not
+// use or close them directly afterwards.
+func FileConn(ep tcpip.Endpoint, wq *waiter.Queue) (net.Conn, error) {
+	if err := ep.GetSockOpt(tcpip.ErrorOption{}); err != nil {
+		return nil, errors.New(err.String())
+	}
+	if _, err := ep.GetRemoteAddress(); err != nil {
+		return nil, errors.New("FileConn: endpoint is not connected: " + err.String())
+	}
+
+	return NewConn(wq, ep), nil
+}
+
+// FileListener adopts an already-listening tcpip.Endpoint, wrapping it in a
+// net.Listener. It is the gonet analogue of the standard library's
+// net.FileListener.
+//
+// Ownership of ep and wq passes to the returned Listener; the caller must
+// not use or close them directly afterwards.
+func FileListener(ep tcpip.Endpoint, wq *waiter.Queue) (net.Listener, error) {
+	if err := ep.GetSockOpt(tcpip.ErrorOption{}); err != nil {
+		return nil, errors.New(err.String())
+	}
+	// ErrNotConnected alone doesn't distinguish a listening endpoint from
+	// one that was never Listen()'d (or has since been closed), so check
+	// the endpoint's actual lifecycle state instead.
+	if tcp.EndpointState(ep.State()) != tcp.StateListen {
+		return nil, errors.New("FileListener: endpoint is not listening")
+	}
+
+	return newListener(ep, wq), nil
+}
+
+// newListener wraps an already-listening endpoint and its wait queue in a
+// Listener, without creating a new endpoint of its own the way NewListener
+// does.
+func newListener(ep tcpip.Endpoint, wq *waiter.Queue) *Listener {
+	return &Listener{ep: ep, wq: wq}
+}
+
+// Detach removes the underlying endpoint and wait queue from c, returning
+// them to the caller. It is the inverse of NewConn/FileConn: it lets the
+// endpoint be handed off to another subsystem (e.g. a tcp.Forwarder) without
+// c.Close() later double-closing it.
+//
+// Once Detach returns, c is spent: calling a method on it that uses the
+// endpoint or wait queue, such as Close, Read or Write, will panic on the
+// nil endpoint/wait queue left behind. Callers must drop their reference to c and use the returned
+// endpoint and wait queue directly from that point on.
+func (c *Conn) Detach() (*waiter.Queue, tcpip.Endpoint) {
+	wq, ep := c.wq, c.ep
+	c.wq, c.ep = nil, nil
+	return wq, ep
+}
+
+// Detach removes the underlying endpoint and wait queue from l, returning
+// them to the caller. It is the inverse of NewListener/FileListener.
+//
+// Once Detach returns, l is spent: calling any method on it, including
+// Close or Accept, will panic on the nil endpoint/wait queue left behind.
+// Callers must drop their reference to l and use the returned endpoint and
+// wait queue directly from that point on.
+func (l *Listener) Detach() (*waiter.Queue, tcpip.Endpoint) {
+	wq, ep := l.wq, l.ep
+	l.wq, l.ep = nil, nil
+	return wq, ep
+}
@@ -0,0 +1,215 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonet
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/netstack/tcpip"
+	"github.com/google/netstack/tcpip/network/ipv4"
+	"github.com/google/netstack/tcpip/transport/tcp"
+	"github.com/google/netstack/waiter"
+)
+
+// TestCloseReaderWithFileConn tests that an endpoint handed out by a
+// tcp.Forwarder can be round-tripped out of the forwarder and back into a
+// Conn via FileConn, and that Conn.Close() still wakes a blocked Read(), just
+// as it does when the Conn is constructed directly with NewConn in
+// TestCloseReaderWithForwarder.
+func TestCloseReaderWithFileConn(t *testing.T) {
+	s, err := newLoopbackStack()
+	if err != nil {
+		t.Fatalf("newLoopbackStack() = %v", err)
+	}
+
+	addr := tcpip.FullAddress{NIC: NICID, Addr: tcpip.Address(net.IPv4(169, 254, 10, 1).To4()), Port: 11211}
+	s.AddAddress(NICID, ipv4.ProtocolNumber, addr.Addr)
+
+	done := make(chan struct{})
+
+	fwd := tcp.NewForwarder(s, 30000, 10, func(r *tcp.ForwarderRequest) {
+		defer close(done)
+
+		var wq waiter.Queue
+		ep, err := r.CreateEndpoint(&wq)
+		if err != nil {
+			t.Fatalf("r.CreateEndpoint() = %v", err)
+		}
+		r.Complete(false)
+
+		c, err := FileConn(ep, &wq)
+		if err != nil {
+			ep.Close()
+			t.Fatalf("FileConn() = %v", err)
+		}
+		defer c.Close()
+
+		time.AfterFunc(time.Millisecond*50, func() {
+			t.Log("c.Close()")
+			c.Close()
+			t.Log("c.Close() ok")
+		})
+
+		buf := make([]byte, 256)
+		t.Log("c.Read()")
+		n, e := c.Read(buf)
+		got, ok := e.(*net.OpError)
+		want := tcpip.ErrConnectionAborted
+		if n != 0 || !ok || got.Err.Error() != want.String() {
+			t.Errorf("c.Read() = (%d, %v), want (0, OpError(%v))", n, e, want)
+		}
+		t.Logf("c.Read() = %d, %v", n, e)
+	})
+	s.SetTransportProtocolHandler(tcp.ProtocolNumber, fwd.HandlePacket)
+
+	sender, err := connect(s, addr)
+	if err != nil {
+		t.Fatalf("connect() = %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("c.Read() didn't unblock")
+	}
+	sender.close()
+}
+
+// TestFileListenerRejectsNonListeningEndpoint tests that FileListener
+// refuses a freshly created endpoint that was never put into the listen
+// state, rather than mistaking "not connected" for "listening".
+func TestFileListenerRejectsNonListeningEndpoint(t *testing.T) {
+	s, err := newLoopbackStack()
+	if err != nil {
+		t.Fatalf("newLoopbackStack() = %v", err)
+	}
+
+	var wq waiter.Queue
+	ep, e := s.NewEndpoint(tcp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+	if e != nil {
+		t.Fatalf("NewEndpoint() = %v", e)
+	}
+	defer ep.Close()
+
+	if _, err := FileListener(ep, &wq); err == nil {
+		t.Errorf("FileListener() on a never-Listen()'d endpoint = nil error, want non-nil")
+	}
+}
+
+// TestFileListenerAcceptsListeningEndpoint tests that FileListener accepts
+// an endpoint that has actually been put into the listen state.
+func TestFileListenerAcceptsListeningEndpoint(t *testing.T) {
+	s, err := newLoopbackStack()
+	if err != nil {
+		t.Fatalf("newLoopbackStack() = %v", err)
+	}
+
+	addr := tcpip.FullAddress{NIC: NICID, Addr: tcpip.Address(net.IPv4(169, 254, 10, 2).To4()), Port: 11212}
+	s.AddAddress(NICID, ipv4.ProtocolNumber, addr.Addr)
+
+	var wq waiter.Queue
+	ep, e := s.NewEndpoint(tcp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+	if e != nil {
+		t.Fatalf("NewEndpoint() = %v", e)
+	}
+	if e := ep.Bind(addr, nil); e != nil {
+		ep.Close()
+		t.Fatalf("ep.Bind() = %v", e)
+	}
+	if e := ep.Listen(10); e != nil {
+		ep.Close()
+		t.Fatalf("ep.Listen() = %v", e)
+	}
+
+	l, err := FileListener(ep, &wq)
+	if err != nil {
+		ep.Close()
+		t.Fatalf("FileListener() = %v", err)
+	}
+	l.Close()
+}
+
+// TestConnDetach tests that Detach() hands back a non-nil endpoint and wait
+// queue, and that the caller can then close the endpoint directly.
+func TestConnDetach(t *testing.T) {
+	s, err := newLoopbackStack()
+	if err != nil {
+		t.Fatalf("newLoopbackStack() = %v", err)
+	}
+
+	addr := tcpip.FullAddress{NIC: NICID, Addr: tcpip.Address(net.IPv4(169, 254, 10, 1).To4()), Port: 11211}
+	s.AddAddress(NICID, ipv4.ProtocolNumber, addr.Addr)
+
+	l, e := NewListener(s, addr, ipv4.ProtocolNumber)
+	if e != nil {
+		t.Fatalf("NewListener() = %v", e)
+	}
+
+	sender, err := connect(s, addr)
+	if err != nil {
+		t.Fatalf("connect() = %v", err)
+	}
+	defer sender.close()
+
+	nc, err := l.Accept()
+	if err != nil {
+		t.Fatalf("l.Accept() = %v", err)
+	}
+	c := nc.(*Conn)
+
+	wq, ep := c.Detach()
+	if wq == nil || ep == nil {
+		t.Fatalf("c.Detach() = (%v, %v), want non-nil", wq, ep)
+	}
+
+	// The endpoint is now owned by the caller: it can be handed to
+	// another subsystem (e.g. a tcp.Forwarder) or closed directly,
+	// without c ever touching it again.
+	ep.Close()
+}
+
+// TestDetachedConnPanics pins down the contract documented on Conn.Detach:
+// once a Conn has been detached, calling a method on it that uses the
+// endpoint -- Close here -- panics rather than silently doing nothing or
+// double-closing the endpoint handed to the caller.
+func TestDetachedConnPanics(t *testing.T) {
+	s, err := newLoopbackStack()
+	if err != nil {
+		t.Fatalf("newLoopbackStack() = %v", err)
+	}
+
+	addr := tcpip.FullAddress{NIC: NICID, Addr: tcpip.Address(net.IPv4(169, 254, 10, 3).To4()), Port: 11213}
+	s.AddAddress(NICID, ipv4.ProtocolNumber, addr.Addr)
+
+	l, e := NewListener(s, addr, ipv4.ProtocolNumber)
+	if e != nil {
+		t.Fatalf("NewListener() = %v", e)
+	}
+	defer l.Close()
+
+	sender, err := connect(s, addr)
+	if err != nil {
+		t.Fatalf("connect() = %v", err)
+	}
+	defer sender.close()
+
+	nc, err := l.Accept()
+	if err != nil {
+		t.Fatalf("l.Accept() = %v", err)
+	}
+	c := nc.(*Conn)
+
+	_, ep := c.Detach()
+	defer ep.Close()
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("c.Close() after Detach() did not panic, want a panic per Detach's documented contract")
+		}
+	}()
+	c.Close()
+}
@@ -0,0 +1,189 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonet
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/google/netstack/tcpip"
+	"github.com/google/netstack/tcpip/buffer"
+	"github.com/google/netstack/tcpip/stack"
+	"github.com/google/netstack/tcpip/transport/udp"
+	"github.com/google/netstack/waiter"
+)
+
+// timeoutError is returned when a blocking operation times out because a
+// deadline was reached or changed while it was in flight.
+type timeoutError struct{}
+
+func (e *timeoutError) Error() string   { return "i/o timeout" }
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return true }
+
+// PacketConn implements the net.PacketConn interface on top of a UDP
+// endpoint of a tcpip.Stack.
+type PacketConn struct {
+	ep tcpip.Endpoint
+	wq *waiter.Queue
+
+	rDeadline deadlineTimer
+	wDeadline deadlineTimer
+}
+
+// NewPacketConn creates a new PacketConn backed by a UDP endpoint of s, bound
+// to addr.
+func NewPacketConn(s *stack.Stack, addr tcpip.FullAddress, netProto tcpip.NetworkProtocolNumber) (*PacketConn, *tcpip.Error) {
+	wq := &waiter.Queue{}
+	ep, err := s.NewEndpoint(udp.ProtocolNumber, netProto, wq)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ep.Bind(addr, nil); err != nil {
+		ep.Close()
+		return nil, err
+	}
+
+	c := &PacketConn{ep: ep, wq: wq}
+	c.rDeadline.init()
+	c.wDeadline.init()
+	return c, nil
+}
+
+// SetDeadline implements net.PacketConn.SetDeadline.
+func (c *PacketConn) SetDeadline(t time.Time) error {
+	c.rDeadline.setDeadline(t)
+	c.wDeadline.setDeadline(t)
+	return nil
+}
+
+// SetReadDeadline implements net.PacketConn.SetReadDeadline.
+func (c *PacketConn) SetReadDeadline(t time.Time) error {
+	c.rDeadline.setDeadline(t)
+	return nil
+}
+
+// SetWriteDeadline implements net.PacketConn.SetWriteDeadline.
+func (c *PacketConn) SetWriteDeadline(t time.Time) error {
+	c.wDeadline.setDeadline(t)
+	return nil
+}
+
+// ReadFrom implements net.PacketConn.ReadFrom.
+func (c *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	cancel := c.rDeadline.cancel()
+
+	select {
+	case <-cancel:
+		return 0, nil, newPacketConnError("read", &timeoutError{})
+	default:
+	}
+
+	e, ch := waiter.NewChannelEntry(nil)
+	c.wq.EventRegister(&e, waiter.EventIn)
+	defer c.wq.EventUnregister(&e)
+
+	for {
+		var addr tcpip.FullAddress
+		v, err := c.ep.Read(&addr)
+		if err == nil {
+			return copy(b, v), fullToUDPAddr(addr), nil
+		}
+
+		if err == tcpip.ErrWouldBlock {
+			select {
+			case <-ch:
+				continue
+			case <-cancel:
+				return 0, nil, newPacketConnError("read", &timeoutError{})
+			}
+		}
+
+		return 0, nil, newPacketConnError("read", errors.New(err.String()))
+	}
+}
+
+// WriteTo implements net.PacketConn.WriteTo.
+func (c *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	ua, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, newPacketConnError("write", errors.New("address of unsupported type "+addrTypeName(addr)))
+	}
+
+	full := udpAddrToFull(ua)
+
+	cancel := c.wDeadline.cancel()
+
+	select {
+	case <-cancel:
+		return 0, newPacketConnError("write", &timeoutError{})
+	default:
+	}
+
+	e, ch := waiter.NewChannelEntry(nil)
+	c.wq.EventRegister(&e, waiter.EventOut)
+	defer c.wq.EventUnregister(&e)
+
+	for {
+		n, err := c.ep.Write(buffer.View(b), &full)
+		if err == nil {
+			return int(n), nil
+		}
+
+		if err == tcpip.ErrWouldBlock {
+			select {
+			case <-ch:
+				continue
+			case <-cancel:
+				return 0, newPacketConnError("write", &timeoutError{})
+			}
+		}
+
+		return 0, newPacketConnError("write", errors.New(err.String()))
+	}
+}
+
+// LocalAddr implements net.PacketConn.LocalAddr.
+func (c *PacketConn) LocalAddr() net.Addr {
+	addr, err := c.ep.GetLocalAddress()
+	if err != nil {
+		return nil
+	}
+	return fullToUDPAddr(addr)
+}
+
+// Close implements net.PacketConn.Close.
+func (c *PacketConn) Close() error {
+	c.ep.Close()
+	return nil
+}
+
+func newPacketConnError(op string, err error) *net.OpError {
+	return &net.OpError{
+		Op:  op,
+		Net: "udp",
+		Err: err,
+	}
+}
+
+func addrTypeName(addr net.Addr) string {
+	if addr == nil {
+		return "<nil>"
+	}
+	return addr.Network()
+}
+
+func fullToUDPAddr(addr tcpip.FullAddress) *net.UDPAddr {
+	return &net.UDPAddr{IP: net.IP(addr.Addr), Port: int(addr.Port)}
+}
+
+func udpAddrToFull(addr *net.UDPAddr) tcpip.FullAddress {
+	return tcpip.FullAddress{
+		Addr: tcpip.Address(addr.IP),
+		Port: uint16(addr.Port),
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gonet
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/netstack/tcpip"
+	"github.com/google/netstack/tcpip/network/ipv4"
+	"github.com/google/netstack/tcpip/network/ipv6"
+)
+
+var packetConnTestAddrs = []struct {
+	name  string
+	proto tcpip.NetworkProtocolNumber
+	addr  tcpip.Address
+}{
+	{"IPv4", ipv4.ProtocolNumber, tcpip.Address(net.IPv4(169, 254, 10, 1).To4())},
+	{"IPv6", ipv6.ProtocolNumber, tcpip.Address(net.ParseIP("fe80::1"))},
+}
+
+// TestPacketConnRoundTrip tests that a packet written with WriteTo to a
+// PacketConn's own address is received back, byte for byte, via ReadFrom,
+// and that the address ReadFrom reports can be used to reply, over both
+// IPv4 and IPv6.
+func TestPacketConnRoundTrip(t *testing.T) {
+	for _, tc := range packetConnTestAddrs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s, err := newLoopbackStack()
+			if err != nil {
+				t.Fatalf("newLoopbackStack() = %v", err)
+			}
+
+			addr := tcpip.FullAddress{NIC: NICID, Addr: tc.addr, Port: 11311}
+			s.AddAddress(NICID, tc.proto, addr.Addr)
+
+			c, e := NewPacketConn(s, addr, tc.proto)
+			if e != nil {
+				t.Fatalf("NewPacketConn() = %v", e)
+			}
+			defer c.Close()
+
+			want := []byte("hello over loopback")
+			dst := c.LocalAddr()
+			if n, err := c.WriteTo(want, dst); err != nil || n != len(want) {
+				t.Fatalf("c.WriteTo() = (%d, %v), want (%d, nil)", n, err, len(want))
+			}
+
+			buf := make([]byte, 256)
+			c.SetReadDeadline(time.Now().Add(5 * time.Second))
+			n, from, err := c.ReadFrom(buf)
+			if err != nil {
+				t.Fatalf("c.ReadFrom() = %v", err)
+			}
+			if got := buf[:n]; string(got) != string(want) {
+				t.Errorf("c.ReadFrom() = %q, want %q", got, want)
+			}
+			if from.String() != dst.String() {
+				t.Errorf("c.ReadFrom() from = %v, want %v", from, dst)
+			}
+		})
+	}
+}
+
+// TestPacketConnCloseReader tests that PacketConn.Close() causes
+// PacketConn.ReadFrom() to unblock, over both IPv4 and IPv6.
+func TestPacketConnCloseReader(t *testing.T) {
+	for _, tc := range packetConnTestAddrs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s, err := newLoopbackStack()
+			if err != nil {
+				t.Fatalf("newLoopbackStack() = %v", err)
+			}
+
+			addr := tcpip.FullAddress{NIC: NICID, Addr: tc.addr, Port: 11211}
+			s.AddAddress(NICID, tc.proto, addr.Addr)
+
+			c, e := NewPacketConn(s, addr, tc.proto)
+			if e != nil {
+				t.Fatalf("NewPacketConn() = %v", e)
+			}
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+
+				time.AfterFunc(time.Millisecond*50, func() {
+					t.Log("c.Close()")
+					c.Close()
+					t.Log("c.Close() ok")
+				})
+
+				buf := make([]byte, 256)
+				t.Log("c.ReadFrom()")
+				n, _, err := c.ReadFrom(buf)
+				got, ok := err.(*net.OpError)
+				want := tcpip.ErrConnectionAborted
+				if n != 0 || !ok || got.Err.Error() != want.String() {
+					t.Errorf("c.ReadFrom() = (%d, %v), want (0, OpError(%v))", n, err, want)
+				}
+				t.Logf("c.ReadFrom() = %d, %v", n, err)
+			}()
+
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Errorf("c.ReadFrom() didn't unblock")
+			}
+		})
+	}
+}
+
+// TestPacketConnDeadlineChange tests that changing the deadline affects a
+// currently blocked ReadFrom, over both IPv4 and IPv6.
+func TestPacketConnDeadlineChange(t *testing.T) {
+	for _, tc := range packetConnTestAddrs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s, err := newLoopbackStack()
+			if err != nil {
+				t.Fatalf("newLoopbackStack() = %v", err)
+			}
+
+			addr := tcpip.FullAddress{NIC: NICID, Addr: tc.addr, Port: 11211}
+			s.AddAddress(NICID, tc.proto, addr.Addr)
+
+			c, e := NewPacketConn(s, addr, tc.proto)
+			if e != nil {
+				t.Fatalf("NewPacketConn() = %v", e)
+			}
+
+			c.SetDeadline(time.Now().Add(time.Minute))
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+
+				time.AfterFunc(time.Millisecond*50, func() {
+					t.Log("c.SetDeadline()")
+					c.SetDeadline(time.Now().Add(time.Millisecond * 10))
+					t.Log("c.SetDeadline() ok")
+				})
+
+				buf := make([]byte, 256)
+				t.Log("c.ReadFrom()")
+				n, _, err := c.ReadFrom(buf)
+				got, ok := err.(*net.OpError)
+				want := "i/o timeout"
+				if n != 0 || !ok || got.Err == nil || got.Err.Error() != want {
+					t.Errorf("c.ReadFrom() = (%d, %v), want (0, OpError(%s))", n, err, want)
+				}
+				t.Logf("c.ReadFrom() = %d, %v", n, err)
+			}()
+
+			select {
+			case <-done:
+			case <-time.After(time.Millisecond * 500):
+				t.Errorf("c.ReadFrom() didn't unblock")
+			}
+		})
+	}
+}
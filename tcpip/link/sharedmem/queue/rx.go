@@ -60,6 +60,13 @@ type Rx struct {
 	tx                 pipe.Tx
 	rx                 pipe.Rx
 	sharedEventFDState *uint32
+
+	// Dropped is the number of packets that Dequeue and DequeueBatch have
+	// skipped over because their descriptors were corrupted (e.g., a
+	// header that is too small, or buffer sizes that don't add up). It is
+	// exposed so that callers can detect stream damage that would
+	// otherwise pass silently.
+	Dropped uint64
 }
 
 // Init initializes the receive queue with the given pipes, and shared state
@@ -86,6 +93,15 @@ func (r *Rx) DisableNotification() {
 // peer. Once they are posted, the peer is free to write to them and will
 // eventually post them back for consumption.
 func (r *Rx) PostBuffers(buffers []RxBuffer) bool {
+	return r.PostBuffersBatch(buffers)
+}
+
+// PostBuffersBatch makes the given buffers available for receiving data from
+// the peer in a single batch: every buffer is written to the tx pipe before
+// a single call to Flush, rather than flushing once per buffer. It is
+// formalized separately from PostBuffers so that the single-flush contract
+// can be tested and relied upon directly.
+func (r *Rx) PostBuffersBatch(buffers []RxBuffer) bool {
 	for i := range buffers {
 		b := r.tx.Push(sizeOfPostedBuffer)
 		if b == nil {
@@ -112,18 +128,51 @@ func (r *Rx) PostBuffers(buffers []RxBuffer) bool {
 // This is similar to append() in that new buffers are appended to "bufs", with
 // reallocation only if "bufs" doesn't have enough capacity.
 func (r *Rx) Dequeue(bufs []RxBuffer) ([]RxBuffer, uint32) {
+	outBufs, size, _ := r.dequeue(bufs)
+	return outBufs, size
+}
+
+// DequeueBatch pulls up to maxPackets packets from the rx pipe in a single
+// call, appending each packet's buffers to outBufs and its total data size to
+// outSizes. packets is the number of packets appended by this call, so
+// len(outSizes) == len(sizes)+packets on return.
+//
+// Like Dequeue, this is similar to append(): outBufs and outSizes are
+// reallocated only if bufs/sizes don't have enough capacity.
+func (r *Rx) DequeueBatch(bufs []RxBuffer, sizes []uint32, maxPackets int) (outBufs []RxBuffer, outSizes []uint32, packets int) {
+	outBufs = bufs
+	outSizes = sizes
+	for packets < maxPackets {
+		newBufs, size, ok := r.dequeue(outBufs)
+		if !ok {
+			break
+		}
+
+		outBufs = newBufs
+		outSizes = append(outSizes, size)
+		packets++
+	}
+
+	return outBufs, outSizes, packets
+}
+
+// dequeue pulls and validates a single packet from the rx pipe, skipping
+// over (and counting in Dropped) any descriptors that fail the corruption
+// checks. ok is false if the pipe has no more descriptors to offer.
+func (r *Rx) dequeue(bufs []RxBuffer) (outBufs []RxBuffer, size uint32, ok bool) {
 	for {
-		outBufs := bufs
+		outBufs = bufs
 
 		// Pull the next descriptor from the rx pipe.
 		b := r.rx.Pull()
 		if b == nil {
-			return bufs, 0
+			return bufs, 0, false
 		}
 
 		if len(b) < sizeOfConsumedPacketHeader {
 			log.Printf("Ignoring packet header: size (%v) is less than header size (%v)", len(b), sizeOfConsumedPacketHeader)
 			r.rx.Flush()
+			r.Dropped++
 			continue
 		}
 
@@ -160,9 +209,10 @@ func (r *Rx) Dequeue(bufs []RxBuffer) ([]RxBuffer, uint32) {
 		if buffersSize < totalDataSize {
 			// The descriptor is corrupted, ignore it.
 			log.Printf("Ignoring packet: actual data size (%v) less than expected size (%v)", buffersSize, totalDataSize)
+			r.Dropped++
 			continue
 		}
 
-		return outBufs, totalDataSize
+		return outBufs, totalDataSize, true
 	}
 }
@@ -0,0 +1,106 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package queue
+
+import "testing"
+
+func TestRxDequeueBatch(t *testing.T) {
+	rx, tx := newRxTxPair()
+
+	want := [][]TxBuffer{
+		{{Offset: 0, Size: 10, ID: 1}},
+		{{Offset: 10, Size: 20, ID: 2}, {Offset: 30, Size: 5, ID: 3}},
+		{{Offset: 40, Size: 1, ID: 4}},
+	}
+	for _, p := range want {
+		if !tx.TransmitPackets(p) {
+			t.Fatalf("TransmitPackets(%+v) = false, want true", p)
+		}
+	}
+
+	// Ask for fewer packets than are available; only that many should
+	// come back, with the rest left queued for the next call.
+	bufs, sizes, packets := rx.DequeueBatch(nil, nil, 2)
+	if packets != 2 {
+		t.Fatalf("DequeueBatch(maxPackets=2) returned %v packets, want 2", packets)
+	}
+	if len(sizes) != packets {
+		t.Fatalf("len(sizes) = %v, want %v", len(sizes), packets)
+	}
+	if sizes[0] != 10 || sizes[1] != 25 {
+		t.Errorf("sizes = %v, want [10 25]", sizes)
+	}
+	if len(bufs) != 3 {
+		t.Errorf("len(bufs) = %v, want 3", len(bufs))
+	}
+
+	// The remaining packet should be returned on the next call.
+	bufs, sizes, packets = rx.DequeueBatch(bufs, sizes, 2)
+	if packets != 1 {
+		t.Fatalf("DequeueBatch(maxPackets=2) returned %v packets, want 1", packets)
+	}
+	if len(sizes) != 3 || sizes[2] != 1 {
+		t.Errorf("sizes = %v, want [10 25 1]", sizes)
+	}
+	if len(bufs) != 4 {
+		t.Errorf("len(bufs) = %v, want 4", len(bufs))
+	}
+
+	// No more packets are queued.
+	if _, _, packets := rx.DequeueBatch(nil, nil, 10); packets != 0 {
+		t.Errorf("DequeueBatch() on empty pipe returned %v packets, want 0", packets)
+	}
+}
+
+func TestRxDequeueBatchSkipsCorrupted(t *testing.T) {
+	rx, tx := newRxTxPair()
+
+	// A packet whose buffer sizes overflow uint32 is corrupted and must
+	// be skipped, incrementing Dropped, rather than counting towards
+	// maxPackets or appearing in the output.
+	if !tx.TransmitPackets([]TxBuffer{
+		{Offset: 0, Size: 0xffffffff, ID: 1},
+		{Offset: 0, Size: 1, ID: 2},
+	}) {
+		t.Fatalf("TransmitPackets() = false, want true")
+	}
+	if !tx.TransmitPackets([]TxBuffer{{Offset: 100, Size: 5, ID: 3}}) {
+		t.Fatalf("TransmitPackets() = false, want true")
+	}
+
+	_, sizes, packets := rx.DequeueBatch(nil, nil, 10)
+	if packets != 1 {
+		t.Fatalf("DequeueBatch() returned %v packets, want 1", packets)
+	}
+	if len(sizes) != 1 || sizes[0] != 5 {
+		t.Errorf("sizes = %v, want [5]", sizes)
+	}
+	if rx.Dropped != 1 {
+		t.Errorf("rx.Dropped = %v, want 1", rx.Dropped)
+	}
+}
+
+func TestRxPostBuffersBatch(t *testing.T) {
+	rx, tx := newRxTxPair()
+
+	bufs := []RxBuffer{
+		{Offset: 0, Size: 10, ID: 1},
+		{Offset: 10, Size: 20, ID: 2},
+	}
+	if !rx.PostBuffersBatch(bufs) {
+		t.Fatalf("PostBuffersBatch() = false, want true")
+	}
+
+	completed := tx.CompletedPackets(nil)
+	if len(completed) != len(bufs) {
+		t.Fatalf("CompletedPackets() returned %v buffers, want %v", len(completed), len(bufs))
+	}
+	for i := range bufs {
+		want := TxBuffer{Offset: bufs[i].Offset, Size: bufs[i].Size, ID: bufs[i].ID}
+		if completed[i] != want {
+			t.Errorf("CompletedPackets()[%d] = %+v, want %+v", i, completed[i], want)
+		}
+	}
+}
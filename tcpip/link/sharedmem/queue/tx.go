@@ -0,0 +1,120 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package queue
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+
+	"github.com/google/netstack/tcpip/link/sharedmem/pipe"
+	"log"
+)
+
+// TxBuffer is the descriptor of a transmit buffer.
+type TxBuffer struct {
+	Offset uint64
+	Size   uint32
+	ID     uint64
+}
+
+// Tx is a transmit queue. It is implemented with one tx and one rx pipe: the
+// tx pipe is used to post packets for transmission to the peer, while the rx
+// pipe is used to receive confirmation of completed transmissions, at which
+// point the buffers are free to be reused.
+//
+// This struct is thread-compatible.
+type Tx struct {
+	tx                 pipe.Tx
+	rx                 pipe.Rx
+	sharedEventFDState *uint32
+}
+
+// Init initializes the transmit queue with the given pipes, and shared state
+// pointer -- the latter is used to enable/disable eventfd notifications.
+func (t *Tx) Init(tx, rx []byte, sharedEventFDState *uint32) {
+	t.sharedEventFDState = sharedEventFDState
+	t.tx.Init(tx)
+	t.rx.Init(rx)
+}
+
+// EnableNotification updates the shared state such that the peer will notify
+// the eventfd when there are completed packets to be dequeued.
+func (t *Tx) EnableNotification() {
+	atomic.StoreUint32(t.sharedEventFDState, eventFDEnabled)
+}
+
+// DisableNotification updates the shared state such that the peer will not
+// notify the eventfd.
+func (t *Tx) DisableNotification() {
+	atomic.StoreUint32(t.sharedEventFDState, eventFDDisabled)
+}
+
+// ShouldNotify reports whether the peer has enabled eventfd notifications,
+// i.e., whether it is (or may soon be) blocked waiting for new packets.
+// Callers should check this after successfully posting a packet with
+// TransmitPackets and, if true, write to the eventfd shared with the peer.
+func (t *Tx) ShouldNotify() bool {
+	return atomic.LoadUint32(t.sharedEventFDState) == eventFDEnabled
+}
+
+// TransmitPackets posts the given buffers as a single packet for the peer to
+// consume. The buffers' contents are concatenated in order by the peer.
+func (t *Tx) TransmitPackets(bufs []TxBuffer) bool {
+	b := t.tx.Push(sizeOfConsumedPacketHeader + sizeOfConsumedBuffer*len(bufs))
+	if b == nil {
+		t.tx.Abort()
+		return false
+	}
+
+	totalDataSize := uint32(0)
+	for i := range bufs {
+		totalDataSize += bufs[i].Size
+	}
+
+	binary.LittleEndian.PutUint32(b[consumedPacketSize:], totalDataSize)
+	binary.LittleEndian.PutUint32(b[consumedPacketReserved:], 0)
+
+	offset := sizeOfConsumedPacketHeader
+	for i := range bufs {
+		tb := &bufs[i]
+		binary.LittleEndian.PutUint64(b[offset+consumedOffset:], tb.Offset)
+		binary.LittleEndian.PutUint32(b[offset+consumedSize:], tb.Size)
+		binary.LittleEndian.PutUint64(b[offset+consumedUserData:], 0)
+		binary.LittleEndian.PutUint64(b[offset+consumedID:], tb.ID)
+		offset += sizeOfConsumedBuffer
+	}
+
+	t.tx.Flush()
+
+	return true
+}
+
+// CompletedPackets returns the buffers that the peer has finished consuming
+// and posted back as free, appending them to out.
+//
+// This is similar to append() in that new buffers are appended to "out",
+// with reallocation only if "out" doesn't have enough capacity.
+func (t *Tx) CompletedPackets(out []TxBuffer) []TxBuffer {
+	for {
+		b := t.rx.Pull()
+		if b == nil {
+			return out
+		}
+
+		if len(b) < sizeOfPostedBuffer {
+			log.Printf("Ignoring buffer completion: size (%v) is less than descriptor size (%v)", len(b), sizeOfPostedBuffer)
+			t.rx.Flush()
+			continue
+		}
+
+		out = append(out, TxBuffer{
+			Offset: binary.LittleEndian.Uint64(b[postedOffset:]),
+			Size:   binary.LittleEndian.Uint32(b[postedSize:]),
+			ID:     binary.LittleEndian.Uint64(b[postedID:]),
+		})
+
+		t.rx.Flush()
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package sharedmem
+
+import (
+	"sync"
+	"syscall"
+
+	"github.com/google/netstack/tcpip"
+	"github.com/google/netstack/tcpip/buffer"
+	"github.com/google/netstack/tcpip/link/sharedmem/queue"
+	"github.com/google/netstack/tcpip/stack"
+)
+
+// minRxBufferSize is the smallest size a posted buffer is allowed to shrink
+// to when sized down to fit the configured MTU; below this, the per-buffer
+// overhead of posting and dequeuing isn't worth paying.
+const minRxBufferSize = 4096
+
+// rxBufferSize returns the size, in bytes, of each buffer posted for the
+// peer to fill in with an incoming packet of up to mtu bytes. A buffer only
+// ever holds a single packet, so there's no point posting more than mtu
+// bytes' worth, down to a floor of minRxBufferSize.
+func rxBufferSize(mtu uint32) uint64 {
+	if mtu < minRxBufferSize {
+		return minRxBufferSize
+	}
+	return uint64(mtu)
+}
+
+// rxQueue holds the receive side of a shared memory queue: the memory region
+// where the peer writes incoming packet data, and the queue.Rx used to post
+// empty buffers and dequeue filled ones.
+type rxQueue struct {
+	// raw is the entire mmap'd data region, kept around so it can be
+	// unmapped in full; data is the sub-slice of it actually available
+	// for packet buffers, after the shared notification state word
+	// carved out of the front by splitSharedState.
+	raw  []byte
+	data []byte
+	q    queue.Rx
+
+	eventFD int
+
+	// quitR and quitW are the read and write ends of a pipe used to
+	// reliably unblock a dispatchLoop goroutine parked in waitEventFD:
+	// closing quitW makes quitR readable, which waitEventFD selects on
+	// alongside eventFD. Relying on eventFD's blocking read alone, as a
+	// plain syscall.Read, would only be interruptible by a signal.
+	quitR, quitW int
+
+	wg   sync.WaitGroup
+	done chan struct{}
+}
+
+func (r *rxQueue) init(mtu uint32, c *QueueConfig) *tcpip.Error {
+	data, err := mmapFD(c.DataFD)
+	if err != nil {
+		return err
+	}
+
+	tx, err := mmapFD(c.TxPipeFD)
+	if err != nil {
+		syscall.Munmap(data)
+		return err
+	}
+
+	rx, err := mmapFD(c.RxPipeFD)
+	if err != nil {
+		syscall.Munmap(data)
+		syscall.Munmap(tx)
+		return err
+	}
+
+	var quit [2]int
+	if err := syscall.Pipe(quit[:]); err != nil {
+		syscall.Munmap(data)
+		syscall.Munmap(tx)
+		syscall.Munmap(rx)
+		return tcpip.ErrInvalidEndpointState
+	}
+
+	state, buffers := splitSharedState(data)
+	r.raw = data
+	r.data = buffers
+	r.eventFD = c.EventFD
+	r.quitR, r.quitW = quit[0], quit[1]
+	r.done = make(chan struct{})
+	r.q.Init(tx, rx, state)
+
+	// Post buffers carved out of the data region for the peer to fill in,
+	// each sized to hold a single packet of up to mtu bytes.
+	bufSize := rxBufferSize(mtu)
+	bufs := make([]queue.RxBuffer, 0, uint64(len(r.data))/bufSize)
+	for offset := uint64(0); offset+bufSize <= uint64(len(r.data)); offset += bufSize {
+		bufs = append(bufs, queue.RxBuffer{Offset: offset, Size: uint32(bufSize), ID: offset})
+	}
+	if !r.q.PostBuffers(bufs) {
+		syscall.Close(r.quitR)
+		syscall.Close(r.quitW)
+		syscall.Munmap(data)
+		syscall.Munmap(tx)
+		syscall.Munmap(rx)
+		return tcpip.ErrNoBufferSpace
+	}
+
+	return nil
+}
+
+// start spawns the dispatcher goroutine that delivers packets received over
+// the shared memory queue to dispatcher.
+func (r *rxQueue) start(dispatcher stack.NetworkDispatcher) {
+	r.wg.Add(1)
+	go r.dispatchLoop(dispatcher)
+}
+
+func (r *rxQueue) dispatchLoop(dispatcher stack.NetworkDispatcher) {
+	defer r.wg.Done()
+
+	for {
+		bufs, size := r.q.Dequeue(nil)
+		if len(bufs) == 0 {
+			// Nothing is available yet; ask the peer to notify us
+			// via the eventfd and block until it does.
+			r.q.EnableNotification()
+
+			// Check again after enabling notifications to avoid
+			// racing with a post that happened just before we
+			// enabled them.
+			bufs, size = r.q.Dequeue(nil)
+			if len(bufs) == 0 {
+				if !waitEventFD(r.eventFD, r.quitR) {
+					r.q.DisableNotification()
+					return
+				}
+				r.q.DisableNotification()
+				continue
+			}
+			r.q.DisableNotification()
+		}
+
+		v := make(buffer.View, 0, size)
+		for _, b := range bufs {
+			v = append(v, r.data[b.Offset:b.Offset+uint64(b.Size)]...)
+		}
+
+		dispatcher.DeliverNetworkPacket(nil, "", networkProtocol(v), v)
+
+		// Return the buffers to the free pool so the peer can reuse
+		// them for future packets.
+		r.q.PostBuffers(bufs)
+
+		select {
+		case <-r.done:
+			return
+		default:
+		}
+	}
+}
+
+// stop signals the dispatcher goroutine to exit and waits for it. Closing
+// quitW unblocks a dispatchLoop that's currently parked in waitEventFD,
+// which a close of done alone would not do.
+func (r *rxQueue) stop() {
+	if r.done == nil {
+		return
+	}
+	close(r.done)
+	syscall.Close(r.quitW)
+	r.wg.Wait()
+}
+
+func (r *rxQueue) cleanup() {
+	syscall.Close(r.quitR)
+	syscall.Munmap(r.raw)
+}
@@ -0,0 +1,161 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+// Package sharedmem provides the implementation of data-link layer endpoints
+// backed by shared memory.
+//
+// Such endpoints can be used in cases when two (or more, via a switch)
+// networking stacks share memory access and want to communicate packets
+// between them, such as when multiple virtual machines are hosted on the
+// same physical machine.
+package sharedmem
+
+import (
+	"sync"
+
+	"github.com/google/netstack/tcpip"
+	"github.com/google/netstack/tcpip/buffer"
+	"github.com/google/netstack/tcpip/stack"
+)
+
+// QueueConfig holds the file descriptors needed to set up a single direction
+// (transmit or receive) shared memory queue: a region of memory shared with
+// the peer where packet payloads are stored, plus the pair of pipes used to
+// exchange buffer descriptors over it, and an eventfd used to wake up a
+// blocked peer when new descriptors are available.
+type QueueConfig struct {
+	// DataFD is a file descriptor for the memory region where the actual
+	// packet data is stored.
+	DataFD int
+
+	// EventFD is a file descriptor for the event used to notify the peer
+	// when new buffers have been posted/consumed.
+	EventFD int
+
+	// TxPipeFD is a file descriptor for the pipe used by this side to
+	// send buffer descriptors to the peer.
+	TxPipeFD int
+
+	// RxPipeFD is a file descriptor for the pipe used by this side to
+	// receive buffer descriptors from the peer.
+	RxPipeFD int
+}
+
+// endpoint is a data-link layer endpoint that uses shared memory to send and
+// receive packets.
+type endpoint struct {
+	mtu  uint32
+	addr tcpip.LinkAddress
+
+	// tx is the transmit queue, used to send packets to the peer.
+	tx txQueue
+
+	// rx is the receive queue, used to receive packets from the peer.
+	rx rxQueue
+
+	mu         sync.Mutex
+	dispatcher stack.NetworkDispatcher
+}
+
+// New creates a new shared-memory-based link endpoint. Receive buffers are
+// rxBufferSize(mtu) bytes each and transmit buffers are txBufferSize bytes
+// each.
+//
+// The caller retains ownership of all the file descriptors in tx and rx and
+// is responsible for closing them. New maps the data and pipe regions, so
+// those descriptors may be closed once New returns. The EventFDs are not
+// duplicated: the endpoint keeps using tx.EventFD and rx.EventFD directly,
+// so they must stay open until the endpoint is closed.
+func New(mtu uint32, linkAddr tcpip.LinkAddress, tx, rx QueueConfig) (stack.LinkEndpoint, *tcpip.Error) {
+	e := &endpoint{
+		mtu:  mtu,
+		addr: linkAddr,
+	}
+
+	if err := e.tx.init(&tx); err != nil {
+		return nil, err
+	}
+
+	if err := e.rx.init(mtu, &rx); err != nil {
+		e.tx.cleanup()
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// MTU implements stack.LinkEndpoint.MTU.
+func (e *endpoint) MTU() uint32 {
+	return e.mtu
+}
+
+// Capabilities implements stack.LinkEndpoint.Capabilities.
+func (e *endpoint) Capabilities() stack.LinkEndpointCapabilities {
+	return 0
+}
+
+// MaxHeaderLength implements stack.LinkEndpoint.MaxHeaderLength.
+func (e *endpoint) MaxHeaderLength() uint16 {
+	return 0
+}
+
+// LinkAddress implements stack.LinkEndpoint.LinkAddress.
+func (e *endpoint) LinkAddress() tcpip.LinkAddress {
+	return e.addr
+}
+
+// WritePacket implements stack.LinkEndpoint.WritePacket. It sends the given
+// packet to the peer over the shared memory transmit queue.
+func (e *endpoint) WritePacket(r *stack.Route, hdr *buffer.Prependable, payload buffer.View, protocol tcpip.NetworkProtocolNumber) *tcpip.Error {
+	views := make([]buffer.View, 0, 2)
+	views = append(views, hdr.UsedBytes())
+	if len(payload) > 0 {
+		views = append(views, payload)
+	}
+
+	return e.tx.transmit(views)
+}
+
+// Attach implements stack.LinkEndpoint.Attach. It starts dispatching packets
+// received over the shared memory receive queue to dispatcher.
+func (e *endpoint) Attach(dispatcher stack.NetworkDispatcher) {
+	e.mu.Lock()
+	e.dispatcher = dispatcher
+	e.mu.Unlock()
+
+	e.rx.start(dispatcher)
+}
+
+// Close stops the dispatcher goroutine, closes its internal quit pipe and
+// unmaps the tx and rx data regions. The mappings of the tx and rx pipe
+// regions are not unmapped.
+func (e *endpoint) Close() {
+	e.rx.stop()
+	e.rx.cleanup()
+	e.tx.cleanup()
+}
+
+// IPv4 and IPv6 EtherTypes, used to classify packets arriving over the
+// shared memory queue by peeking at the IP version nibble, since the queue
+// carries raw network-layer packets with no link-layer framing of its own.
+const (
+	ipv4ProtocolNumber tcpip.NetworkProtocolNumber = 0x0800
+	ipv6ProtocolNumber tcpip.NetworkProtocolNumber = 0x86dd
+)
+
+func networkProtocol(v buffer.View) tcpip.NetworkProtocolNumber {
+	if len(v) == 0 {
+		return 0
+	}
+	switch v[0] >> 4 {
+	case 4:
+		return ipv4ProtocolNumber
+	case 6:
+		return ipv6ProtocolNumber
+	}
+	return 0
+}
@@ -0,0 +1,95 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package sharedmem
+
+import (
+	"encoding/binary"
+	"syscall"
+	"unsafe"
+
+	"github.com/google/netstack/tcpip"
+)
+
+// mmapFD maps the entirety of the file referenced by fd into memory and
+// returns the resulting slice.
+func mmapFD(fd int) ([]byte, *tcpip.Error) {
+	var stat syscall.Stat_t
+	if err := syscall.Fstat(fd, &stat); err != nil {
+		return nil, tcpip.ErrInvalidEndpointState
+	}
+
+	b, err := syscall.Mmap(fd, 0, int(stat.Size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, tcpip.ErrInvalidEndpointState
+	}
+
+	return b, nil
+}
+
+// sharedStateSize is the size, in bytes, of the eventfd notification state
+// word carved out of the front of a data region shared between peers.
+const sharedStateSize = 4
+
+// splitSharedState carves the eventfd notification state word out of the
+// front of data -- a region backed by the same DataFD mapped by both the tx
+// and rx side of a single direction's queue -- and returns a pointer to it
+// along with the remainder of data, which is what's actually available for
+// packet buffers. Because both peers map the same underlying file, writes
+// through the returned pointer by one side are visible to the other, unlike
+// a plain process-local variable.
+func splitSharedState(data []byte) (*uint32, []byte) {
+	return (*uint32)(unsafe.Pointer(&data[0])), data[sharedStateSize:]
+}
+
+// writeEventFD signals fd, waking up a peer blocked in waitEventFD on it.
+func writeEventFD(fd int) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], 1)
+	syscall.Write(fd, buf[:])
+}
+
+// waitEventFD blocks until fd is readable, consuming its counter, or quit is
+// readable, whichever happens first. It returns false in the latter case, in
+// which case the caller should stop waiting rather than retry; unlike a bare
+// blocking read on fd, this is reliably interruptible even while the read
+// would otherwise block forever.
+func waitEventFD(fd, quit int) bool {
+	for {
+		var set syscall.FdSet
+		fdSet(&set, fd)
+		fdSet(&set, quit)
+		nfd := fd
+		if quit > nfd {
+			nfd = quit
+		}
+
+		if err := syscall.Select(nfd+1, &set, nil, nil, nil); err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			return false
+		}
+
+		if fdIsSet(&set, quit) {
+			return false
+		}
+		if fdIsSet(&set, fd) {
+			var buf [8]byte
+			syscall.Read(fd, buf[:])
+			return true
+		}
+	}
+}
+
+func fdSet(set *syscall.FdSet, fd int) {
+	set.Bits[fd/64] |= 1 << uint(fd%64)
+}
+
+func fdIsSet(set *syscall.FdSet, fd int) bool {
+	return set.Bits[fd/64]&(1<<uint(fd%64)) != 0
+}
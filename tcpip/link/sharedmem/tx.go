@@ -0,0 +1,151 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package sharedmem
+
+import (
+	"sync"
+	"syscall"
+
+	"github.com/google/netstack/tcpip"
+	"github.com/google/netstack/tcpip/buffer"
+	"github.com/google/netstack/tcpip/link/sharedmem/queue"
+)
+
+// txBufferSize is the size, in bytes, of each buffer carved out of the tx
+// data region and handed out by the free list below.
+const txBufferSize = 65536
+
+// txQueue holds the transmit side of a shared memory queue: the memory
+// region where this side writes outgoing packet data, the queue.Tx used to
+// post filled buffers and reclaim completed ones, and a free list of
+// buffers carved out of the data region.
+type txQueue struct {
+	// raw is the entire mmap'd data region, kept around so it can be
+	// unmapped in full; data is the sub-slice of it actually available
+	// for packet buffers, after the shared notification state word
+	// carved out of the front by splitSharedState.
+	raw  []byte
+	data []byte
+	q    queue.Tx
+
+	// eventFD is written to, via writeEventFD, to wake the peer's
+	// dispatch loop after a successful TransmitPackets, but only if
+	// queue.Tx.ShouldNotify reports that it's waiting for one.
+	eventFD int
+
+	mu   sync.Mutex
+	free []queue.TxBuffer
+}
+
+func (t *txQueue) init(c *QueueConfig) *tcpip.Error {
+	data, err := mmapFD(c.DataFD)
+	if err != nil {
+		return err
+	}
+
+	tx, err := mmapFD(c.TxPipeFD)
+	if err != nil {
+		syscall.Munmap(data)
+		return err
+	}
+
+	rx, err := mmapFD(c.RxPipeFD)
+	if err != nil {
+		syscall.Munmap(data)
+		syscall.Munmap(tx)
+		return err
+	}
+
+	state, buffers := splitSharedState(data)
+	t.raw = data
+	t.data = buffers
+	t.eventFD = c.EventFD
+	t.q.Init(tx, rx, state)
+
+	for offset := uint64(0); offset+txBufferSize <= uint64(len(t.data)); offset += txBufferSize {
+		t.free = append(t.free, queue.TxBuffer{Offset: offset, Size: txBufferSize, ID: offset})
+	}
+
+	return nil
+}
+
+// alloc removes and returns n buffers from the free list, reclaiming any
+// newly-completed transmissions from the peer first if necessary. It returns
+// nil, taking nothing, if fewer than n buffers are available.
+func (t *txQueue) alloc(n int) []queue.TxBuffer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.free) < n {
+		t.free = t.q.CompletedPackets(t.free)
+	}
+
+	if len(t.free) < n {
+		return nil
+	}
+
+	bufs := append([]queue.TxBuffer(nil), t.free[len(t.free)-n:]...)
+	t.free = t.free[:len(t.free)-n]
+	return bufs
+}
+
+// transmit copies the concatenated views into buffers allocated from the
+// free list and posts them to the peer as a single packet.
+func (t *txQueue) transmit(views []buffer.View) *tcpip.Error {
+	size := 0
+	for _, v := range views {
+		size += len(v)
+	}
+
+	n := (size + txBufferSize - 1) / txBufferSize
+	if n == 0 {
+		n = 1
+	}
+
+	bufs := t.alloc(n)
+	if bufs == nil {
+		return tcpip.ErrWouldBlock
+	}
+
+	offset := 0
+	for i := range views {
+		v := views[i]
+		for len(v) > 0 {
+			b := &bufs[offset/txBufferSize]
+			start := uint64(offset % txBufferSize)
+			dst := t.data[b.Offset+start : b.Offset+uint64(b.Size)]
+			copied := copy(dst, v)
+			v = v[copied:]
+			offset += copied
+		}
+	}
+
+	for i := range bufs {
+		bufs[i].Size = txBufferSize
+	}
+	if r := size % txBufferSize; r != 0 || size == 0 {
+		bufs[len(bufs)-1].Size = uint32(r)
+	}
+
+	if !t.q.TransmitPackets(bufs) {
+		t.mu.Lock()
+		t.free = append(t.free, bufs...)
+		t.mu.Unlock()
+		return tcpip.ErrWouldBlock
+	}
+
+	if t.q.ShouldNotify() {
+		writeEventFD(t.eventFD)
+	}
+
+	return nil
+}
+
+func (t *txQueue) cleanup() {
+	syscall.Munmap(t.raw)
+}